@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// acceptsJSON reports whether the client asked for a JSON response instead
+// of the default HTML page, so scripting the server doesn't require
+// scraping the list template.
+func acceptsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// apiFilesHandler serves GET/POST /api/v1/files.
+func apiFilesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		files, err := listFilesFor(principalFromContext(r.Context()))
+		if err != nil {
+			log.Printf("Error listing files: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"files": files})
+	case http.MethodPost:
+		apiUploadHandler(w, r)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func apiUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 500<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "file too large or malformed request")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid file")
+		return
+	}
+	defer file.Close()
+
+	name := filepath.Base(header.Filename)
+	if !validateFilename(name) {
+		writeJSONError(w, http.StatusBadRequest, "invalid filename")
+		return
+	}
+
+	principal := principalFromContext(r.Context())
+	key, err := resolveUploadKey(principal, name, header.Size)
+	if err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "quota exceeded")
+			return
+		}
+		log.Printf("Quota check error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if principal != nil {
+		defer releaseQuota(principal.Name, header.Size)
+	}
+
+	size, err := putFile(key, file)
+	if err != nil {
+		if errors.Is(err, ErrNameConflict) {
+			writeJSONError(w, http.StatusConflict, "file already exists")
+			return
+		}
+		log.Printf("Save file error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	log.Printf("Uploaded: %s", name)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"name": name,
+		"size": size,
+		"url":  fmt.Sprintf("/download/%s", key),
+	})
+}
+
+// apiFileHandler serves GET/DELETE /api/v1/files/{name}.
+func apiFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/files/")
+	if !validateKey(name) {
+		writeJSONError(w, http.StatusBadRequest, "invalid filename")
+		return
+	}
+
+	if owner, rest, ok := strings.Cut(name, "/"); ok && rest != "" {
+		if principal := principalFromContext(r.Context()); principal == nil || principal.Name != owner {
+			writeJSONError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := backend.ServeFile(w, r, name); err != nil {
+			if errors.Is(err, ErrNotExist) {
+				writeJSONError(w, http.StatusNotFound, "file not found")
+				return
+			}
+			log.Printf("Serve file error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}
+	case http.MethodDelete:
+		if err := deleteFile(name); err != nil {
+			if errors.Is(err, ErrNotExist) {
+				writeJSONError(w, http.StatusNotFound, "file not found")
+				return
+			}
+			log.Printf("Delete error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		log.Printf("Deleted: %s", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// rawPutHandler implements a transfer.sh-style `curl -T file $url/name.txt`
+// upload: the request body is streamed straight to the backend under the
+// given name, honoring an optional Expires form/query value.
+func rawPutHandler(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(strings.TrimPrefix(r.URL.Path, "/"))
+	if !validateFilename(name) {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	expires, err := parseExpires(r.URL.Query().Get("expires"))
+	if err != nil {
+		http.Error(w, "Invalid expires value", http.StatusBadRequest)
+		return
+	}
+
+	size := r.ContentLength
+	if size < 0 {
+		size = 0
+	}
+	principal := principalFromContext(r.Context())
+	key, err := resolveUploadKey(principal, name, size)
+	if err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			http.Error(w, "Quota exceeded", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Quota check error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if principal != nil {
+		defer releaseQuota(principal.Name, size)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 500<<20)
+	if _, err := putFile(key, r.Body); err != nil {
+		if errors.Is(err, ErrNameConflict) {
+			http.Error(w, "File already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Save file error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	owner, err := ownerToken(w, r)
+	if err != nil {
+		log.Printf("Owner token error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	deleteToken, err := randomToken(16)
+	if err != nil {
+		log.Printf("Delete token error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := putShortLinkEntry(shortLinkEntry{
+		Key:         key,
+		OwnerToken:  owner,
+		DeleteToken: deleteToken,
+		Expires:     expires,
+	}); err != nil {
+		log.Printf("Index write error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	url := fmt.Sprintf("/download/%s", key)
+	w.Header().Set("X-Url", url)
+	w.Header().Set("X-Delete-Token", deleteToken)
+	if !expires.IsZero() {
+		w.Header().Set("X-Expires", strconv.FormatInt(expires.Unix(), 10))
+	}
+	log.Printf("Uploaded (raw PUT): %s", key)
+	fmt.Fprintln(w, url)
+}
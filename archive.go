@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// errForbiddenArchiveName is returned by archiveNames when a requested `f=`
+// key belongs to another user's namespace.
+var errForbiddenArchiveName = errors.New("forbidden")
+
+// archiveNames resolves the `f=` query parameters into the list of keys to
+// bundle, falling back to every file visible to the requesting principal
+// when none are given.
+func archiveNames(r *http.Request) ([]string, error) {
+	principal := principalFromContext(r.Context())
+
+	names := r.URL.Query()["f"]
+	for _, name := range names {
+		if !validateKey(name) {
+			return nil, fmt.Errorf("invalid filename %q", name)
+		}
+		if owner, rest, ok := strings.Cut(name, "/"); ok && rest != "" {
+			if principal == nil || principal.Name != owner {
+				return nil, errForbiddenArchiveName
+			}
+		}
+	}
+	if len(names) > 0 {
+		return names, nil
+	}
+
+	files, err := listFilesFor(principal)
+	if err != nil {
+		return nil, err
+	}
+	names = make([]string, 0, len(files))
+	for _, f := range files {
+		if principal != nil {
+			names = append(names, principal.Name+"/"+f.Name)
+			continue
+		}
+		names = append(names, f.Name)
+	}
+	return names, nil
+}
+
+func archiveZipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	names, err := archiveNames(r)
+	if err != nil {
+		if errors.Is(err, errForbiddenArchiveName) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="archive.zip"`)
+	w.Header().Set("Content-Type", "application/zip")
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	flusher, _ := w.(http.Flusher)
+
+	for _, name := range names {
+		rc, err := backend.Get(name)
+		if err != nil {
+			log.Printf("Archive read error for %s: %v", name, err)
+			continue
+		}
+		fw, err := zw.Create(name)
+		if err != nil {
+			rc.Close()
+			log.Printf("Archive entry error for %s: %v", name, err)
+			continue
+		}
+		if _, err := io.Copy(fw, rc); err != nil {
+			log.Printf("Archive copy error for %s: %v", name, err)
+		}
+		rc.Close()
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func archiveTarGzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	names, err := archiveNames(r)
+	if err != nil {
+		if errors.Is(err, errForbiddenArchiveName) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="archive.tar.gz"`)
+	w.Header().Set("Content-Type", "application/gzip")
+
+	gw := gzip.NewWriter(w)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+	flusher, _ := w.(http.Flusher)
+
+	for _, name := range names {
+		size, err := backend.Size(name)
+		if err != nil {
+			log.Printf("Archive stat error for %s: %v", name, err)
+			continue
+		}
+		rc, err := backend.Get(name)
+		if err != nil {
+			log.Printf("Archive read error for %s: %v", name, err)
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: size, Mode: 0644}); err != nil {
+			rc.Close()
+			log.Printf("Archive header error for %s: %v", name, err)
+			continue
+		}
+		if _, err := io.Copy(tw, rc); err != nil {
+			log.Printf("Archive copy error for %s: %v", name, err)
+		}
+		rc.Close()
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var tokenBucket = []byte("tokens")
+
+// User is one line of the htpasswd-style users file: name, bcrypt hash,
+// upload quota in bytes, and whether the account can manage /admin/users.
+type User struct {
+	Name         string
+	PasswordHash string
+	Quota        int64
+	Admin        bool
+}
+
+type principalContextKeyType struct{}
+
+var principalContextKey = principalContextKeyType{}
+
+var (
+	authEnabled bool
+	publicRead  bool
+	usersMu     sync.RWMutex
+	users       map[string]*User
+)
+
+func principalFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(principalContextKey).(*User)
+	return u
+}
+
+// loadUsers parses "name:bcryptHash:quota[:admin]" lines, one user per line,
+// blank lines and lines starting with "#" are ignored.
+func loadUsers(path string) (map[string]*User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := map[string]*User{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("malformed users file line: %q", line)
+		}
+		quota, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota for user %q: %w", parts[0], err)
+		}
+		result[parts[0]] = &User{
+			Name:         parts[0],
+			PasswordHash: parts[1],
+			Quota:        quota,
+			Admin:        len(parts) > 3 && parts[3] == "admin",
+		}
+	}
+	return result, scanner.Err()
+}
+
+func authenticate(r *http.Request) (*User, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, false
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		usersMu.RLock()
+		user := users[username]
+		usersMu.RUnlock()
+		if user == nil {
+			return nil, false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return nil, false
+		}
+		return user, true
+	}
+
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		username, ok, err := lookupToken(token)
+		if err != nil || !ok {
+			return nil, false
+		}
+		usersMu.RLock()
+		user := users[username]
+		usersMu.RUnlock()
+		if user == nil {
+			return nil, false
+		}
+		return user, true
+	}
+
+	return nil, false
+}
+
+type tokenRecord struct {
+	Username string    `json:"username"`
+	Created  time.Time `json:"created"`
+}
+
+func mintToken(username string) (string, error) {
+	token, err := randomToken(24)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(tokenRecord{Username: username, Created: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	err = shortLinkDB.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(tokenBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(token), data)
+	})
+	return token, err
+}
+
+func revokeToken(token string) error {
+	return shortLinkDB.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(tokenBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(token))
+	})
+}
+
+func lookupToken(token string) (string, bool, error) {
+	var record tokenRecord
+	found := false
+	err := shortLinkDB.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(tokenBucket)
+		if bucket == nil {
+			return nil
+		}
+		data := bucket.Get([]byte(token))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &record)
+	})
+	return record.Username, found, err
+}
+
+// ErrQuotaExceeded is returned by reserveQuota/resolveUploadKey when an
+// upload would push an authenticated user over their configured quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// quotaMu guards quotaReserved. A single package-level mutex rather than a
+// per-user map mirrors LocalBackend's own dedupMu: simple, and the critical
+// section (a usageBytes listing plus a map update) is short enough that
+// serializing across users isn't a real bottleneck.
+var (
+	quotaMu       sync.Mutex
+	quotaReserved = map[string]int64{}
+)
+
+// reserveQuota checks size bytes against principal's quota - their existing
+// usage plus anything already reserved by other in-flight uploads - and, if
+// there's room, reserves it. This closes the gap between checking and
+// writing: two concurrent uploads that are each individually under quota
+// can no longer both pass the check and jointly exceed it, because the
+// second call sees the first's reservation. Callers must releaseQuota once
+// the upload finishes or is abandoned, successful or not.
+func reserveQuota(principal *User, size int64) error {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	used, err := usageBytes(principal.Name)
+	if err != nil {
+		return err
+	}
+	if used+quotaReserved[principal.Name]+size > principal.Quota {
+		return ErrQuotaExceeded
+	}
+	quotaReserved[principal.Name] += size
+	return nil
+}
+
+// releaseQuota gives back a reservation made by reserveQuota. Once the
+// upload it was held for has either landed (so usageBytes now counts it)
+// or been abandoned, the reservation no longer needs to be held.
+func releaseQuota(username string, size int64) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	quotaReserved[username] -= size
+	if quotaReserved[username] <= 0 {
+		delete(quotaReserved, username)
+	}
+}
+
+// resolveUploadKey returns the storage key an upload of size bytes named
+// name should be written under. Authenticated uploads are namespaced under
+// "<user>/" and checked against the user's quota; anonymous uploads (or any
+// upload when auth is disabled) use name as-is. On success the caller is
+// holding a quota reservation it must release with releaseQuota once the
+// write completes or is abandoned.
+func resolveUploadKey(principal *User, name string, size int64) (string, error) {
+	if principal == nil {
+		return name, nil
+	}
+	if err := reserveQuota(principal, size); err != nil {
+		return "", err
+	}
+	return principal.Name + "/" + name, nil
+}
+
+// usageBytes sums the exact size of everything a user has already stored,
+// so uploadHandler can reject uploads that would exceed their quota.
+func usageBytes(username string) (int64, error) {
+	files, err := backend.ListPrefix(username)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Bytes
+	}
+	return total, nil
+}
+
+// adminUsersHandler lets an admin principal mint or revoke bearer tokens.
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromContext(r.Context())
+	if principal == nil || !principal.Admin {
+		writeJSONError(w, http.StatusForbidden, "admin access required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		target := r.FormValue("username")
+		usersMu.RLock()
+		_, ok := users[target]
+		usersMu.RUnlock()
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "unknown user")
+			return
+		}
+		token, err := mintToken(target)
+		if err != nil {
+			log.Printf("Mint token error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"token": token})
+	case http.MethodDelete:
+		token := r.FormValue("token")
+		if token == "" {
+			writeJSONError(w, http.StatusBadRequest, "token is required")
+			return
+		}
+		if err := revokeToken(token); err != nil {
+			log.Printf("Revoke token error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
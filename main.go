@@ -2,9 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -18,6 +19,7 @@ import (
 
 var (
 	baseDir   string
+	backend   StorageBackend
 	templates *template.Template
 )
 
@@ -52,15 +54,21 @@ const htmlTmpl = `<!DOCTYPE html>
     </div>
 
     <h2>Files ({{.FileCount}})</h2>
+    <form id="archive-form" method="get"></form>
+    <div>
+        <button type="submit" form="archive-form" formaction="/archive.zip">Download selected (.zip)</button>
+        <button type="submit" form="archive-form" formaction="/archive.tar.gz">Download selected (.tar.gz)</button>
+    </div>
     <ul>
         {{range .Files}}
             <li>
                 <span>
-                    <a href="/download/{{.Name}}">{{.Name}}</a>
+                    <input type="checkbox" name="f" value="{{.Key}}" form="archive-form">
+                    <a href="/download/{{.Key}}">{{.Name}}</a>
                 </span>
                 <div>
                     <span class="meta">{{.Size}}</span>
-                    <form action="/delete/{{.Name}}" method="post" onsubmit="return confirm('Are you sure you want to delete {{.Name}}?')" style="display:inline;">
+                    <form action="/delete/{{.Key}}" method="post" onsubmit="return confirm('Are you sure you want to delete {{.Name}}?')" style="display:inline;">
                         <input type="hidden" name="_method" value="DELETE">
                         <button type="submit">Delete</button>
                     </form>
@@ -74,12 +82,22 @@ const htmlTmpl = `<!DOCTYPE html>
 </html>`
 
 type FileInfo struct {
+	Name  string
+	Size  string
+	Bytes int64
+}
+
+// fileRow is the HTML template's view of a file: Key is the full storage
+// key to use in hrefs/form actions, Name is the bare filename to display.
+// For anonymous listings the two are the same.
+type fileRow struct {
+	Key  string
 	Name string
 	Size string
 }
 
 type TemplateData struct {
-	Files     []FileInfo
+	Files     []fileRow
 	FileCount int
 }
 
@@ -104,19 +122,24 @@ func init() {
 	}
 }
 
-func getSafePath(filename string) (string, bool) {
-	if filename == "" || filename == "." || filename == ".." {
-		return "", false
-	}
-	if strings.ContainsAny(filename, "/\\") {
-		return "", false
+// validateFilename rejects names that could escape the backend's key
+// namespace (path separators, ".", "..") before they ever reach a backend.
+func validateFilename(filename string) bool {
+	return filename != "" && filename != "." && filename != ".." && !strings.ContainsAny(filename, "/\\")
+}
+
+// validateKey is like validateFilename but also allows the "<user>/<name>"
+// form used for per-user upload directories.
+func validateKey(key string) bool {
+	if key == "" {
+		return false
 	}
-	fullPath := filepath.Join(baseDir, filename)
-	cleanPath := filepath.Clean(fullPath)
-	if !strings.HasPrefix(cleanPath, baseDir) {
-		return "", false
+	for _, segment := range strings.Split(key, "/") {
+		if !validateFilename(segment) {
+			return false
+		}
 	}
-	return cleanPath, true
+	return true
 }
 
 func securityMiddleware(next http.Handler) http.Handler {
@@ -127,6 +150,11 @@ func securityMiddleware(next http.Handler) http.Handler {
 
 		if r.Method == http.MethodOptions {
 			w.Header().Set("Allow", "GET, POST, DELETE")
+			if strings.HasPrefix(r.URL.Path, "/files") {
+				w.Header().Set("Tus-Resumable", tusResumableVersion)
+				w.Header().Set("Tus-Version", tusResumableVersion)
+				w.Header().Set("Tus-Extension", tusExtensions)
+			}
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -138,39 +166,64 @@ func securityMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if authEnabled {
+			if user, ok := authenticate(r); ok {
+				r = r.WithContext(context.WithValue(r.Context(), principalContextKey, user))
+			} else if !(publicRead && r.Method == http.MethodGet) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="gofile"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-func listHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listHandler(w, r)
+	case http.MethodPut:
+		rawPutHandler(w, r)
+	default:
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
 	}
+}
 
-	entries, err := os.ReadDir(baseDir)
+// listFilesFor returns the files visible to principal: everything under
+// their own "<name>/" prefix when authenticated, or the full flat listing
+// otherwise.
+func listFilesFor(principal *User) ([]FileInfo, error) {
+	if principal != nil {
+		return backend.ListPrefix(principal.Name)
+	}
+	return backend.List()
+}
+
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromContext(r.Context())
+	files, err := listFilesFor(principal)
 	if err != nil {
-		log.Printf("Error reading directory: %v", err)
+		log.Printf("Error listing files: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	files := make([]FileInfo, 0, len(entries))
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		info, err := entry.Info()
-		if err != nil {
-			continue
+	if acceptsJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]any{"files": files})
+		return
+	}
+
+	rows := make([]fileRow, len(files))
+	for i, f := range files {
+		rows[i] = fileRow{Key: f.Name, Name: f.Name, Size: f.Size}
+		if principal != nil {
+			rows[i].Key = principal.Name + "/" + f.Name
 		}
-		files = append(files, FileInfo{
-			Name: entry.Name(),
-			Size: formatSize(info.Size()),
-		})
 	}
 
-	if err := templates.Execute(w, TemplateData{Files: files, FileCount: len(files)}); err != nil {
+	if err := templates.Execute(w, TemplateData{Files: rows, FileCount: len(rows)}); err != nil {
 		log.Printf("Template execution error: %v", err)
 	}
 }
@@ -194,32 +247,47 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	path, ok := getSafePath(filepath.Base(header.Filename))
-	if !ok {
+	name := filepath.Base(header.Filename)
+	if !validateFilename(name) {
 		http.Error(w, "Invalid filename", http.StatusBadRequest)
 		return
 	}
 
-	if _, err := os.Stat(path); err == nil {
-		http.Error(w, "File already exists", http.StatusConflict)
-		return
-	}
-
-	dst, err := os.Create(path)
+	principal := principalFromContext(r.Context())
+	key, err := resolveUploadKey(principal, name, header.Size)
 	if err != nil {
-		log.Printf("Create file error: %v", err)
+		if errors.Is(err, ErrQuotaExceeded) {
+			http.Error(w, "Quota exceeded", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Quota check error: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
+	if principal != nil {
+		defer releaseQuota(principal.Name, header.Size)
+	}
 
-	if _, err := io.Copy(dst, file); err != nil {
+	size, err := putFile(key, file)
+	if err != nil {
+		if errors.Is(err, ErrNameConflict) {
+			http.Error(w, "File already exists", http.StatusConflict)
+			return
+		}
 		log.Printf("Save file error: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	log.Printf("Uploaded: %s", header.Filename)
+	if acceptsJSON(r) {
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"name": name,
+			"size": size,
+			"url":  fmt.Sprintf("/download/%s", key),
+		})
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -230,20 +298,29 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := strings.TrimPrefix(r.URL.Path, "/download/")
-	path, ok := getSafePath(name)
-	if !ok {
+	if !validateKey(name) {
 		http.Error(w, "Invalid filename", http.StatusBadRequest)
 		return
 	}
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		http.Error(w, "File Not Found", http.StatusNotFound)
-		return
+	if owner, rest, ok := strings.Cut(name, "/"); ok && rest != "" {
+		if principal := principalFromContext(r.Context()); principal == nil || principal.Name != owner {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
 	w.Header().Set("Content-Type", "application/octet-stream")
-	http.ServeFile(w, r, path)
+	if err := backend.ServeFile(w, r, name); err != nil {
+		if errors.Is(err, ErrNotExist) {
+			http.Error(w, "File Not Found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Serve file error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 }
 
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
@@ -258,55 +335,134 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := strings.TrimPrefix(r.URL.Path, "/delete/")
-	path, ok := getSafePath(name)
-	if !ok {
+	if !validateKey(name) {
 		http.Error(w, "Invalid filename", http.StatusBadRequest)
 		return
 	}
 
-	if err := os.Remove(path); err != nil {
-		if os.IsNotExist(err) {
+	if owner, rest, ok := strings.Cut(name, "/"); ok && rest != "" {
+		if principal := principalFromContext(r.Context()); principal == nil || principal.Name != owner {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	if deleteErr := deleteFile(name); deleteErr != nil {
+		if errors.Is(deleteErr, ErrNotExist) {
 			http.Error(w, "File Not Found", http.StatusNotFound)
 			return
 		}
-		log.Printf("Delete error: %v", err)
+		log.Printf("Delete error: %v", deleteErr)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	log.Printf("Deleted: %s", name)
+	if acceptsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Printf("Usage: %s <directory> <port>\n", os.Args[0])
-		os.Exit(1)
+	dir := flag.String("dir", "./data", "local directory to serve (backend=local only)")
+	port := flag.Int("port", 8080, "port to listen on")
+	backendKind := flag.String("backend", "local", "storage backend: local, s3, or webdav")
+	bucket := flag.String("bucket", "", "bucket name (backend=s3)")
+	region := flag.String("region", "us-east-1", "region (backend=s3)")
+	endpoint := flag.String("endpoint", "", "custom endpoint URL for S3-compatible stores (backend=s3)")
+	webdavURL := flag.String("webdav-url", "", "WebDAV base URL (backend=webdav)")
+	webdavUser := flag.String("webdav-user", "", "WebDAV username (backend=webdav)")
+	webdavPass := flag.String("webdav-pass", "", "WebDAV password (backend=webdav)")
+	indexPath := flag.String("index-db", "shortlinks.db", "path to the short-link bbolt index")
+	tusDir := flag.String("tus-tmp-dir", "tus-tmp", "directory for in-progress tus uploads")
+	usersFile := flag.String("users-file", "", "enable authentication using this htpasswd-style users file")
+	flag.BoolVar(&publicRead, "public-read", false, "allow anonymous downloads/listing while requiring auth for upload/delete")
+	flag.Parse()
+
+	if flag.NArg() == 2 {
+		*dir = flag.Arg(0)
+		if p, err := strconv.Atoi(flag.Arg(1)); err == nil {
+			*port = p
+		}
+	}
+
+	if *port < 1 || *port > 65535 {
+		log.Fatalf("Invalid port: %d", *port)
 	}
 
 	var err error
-	baseDir, err = filepath.Abs(os.Args[1])
-	if err != nil {
-		log.Fatalf("Invalid directory: %v", err)
+	switch *backendKind {
+	case "local":
+		baseDir, err = filepath.Abs(*dir)
+		if err != nil {
+			log.Fatalf("Invalid directory: %v", err)
+		}
+		if err := os.MkdirAll(baseDir, 0755); err != nil {
+			log.Fatalf("Failed to create directory: %v", err)
+		}
+		backend = NewLocalBackend(baseDir)
+	case "s3":
+		if *bucket == "" {
+			log.Fatal("-bucket is required for backend=s3")
+		}
+		backend, err = NewS3Backend(context.Background(), *bucket, *region, *endpoint)
+		if err != nil {
+			log.Fatalf("Failed to configure S3 backend: %v", err)
+		}
+	case "webdav":
+		if *webdavURL == "" {
+			log.Fatal("-webdav-url is required for backend=webdav")
+		}
+		backend = NewWebDAVBackend(*webdavURL, *webdavUser, *webdavPass)
+	default:
+		log.Fatalf("Unknown backend: %s", *backendKind)
 	}
 
-	if err := os.MkdirAll(baseDir, 0755); err != nil {
-		log.Fatalf("Failed to create directory: %v", err)
+	if *usersFile != "" {
+		users, err = loadUsers(*usersFile)
+		if err != nil {
+			log.Fatalf("Failed to load users file: %v", err)
+		}
+		authEnabled = true
+	}
+
+	shortLinkDB, err = openShortLinkDB(*indexPath)
+	if err != nil {
+		log.Fatalf("Failed to open short-link index: %v", err)
 	}
+	defer shortLinkDB.Close()
 
-	port, err := strconv.Atoi(os.Args[2])
-	if err != nil || port < 1 || port > 65535 {
-		log.Fatalf("Invalid port: %s", os.Args[2])
+	tusTmpDir, err = filepath.Abs(*tusDir)
+	if err != nil {
+		log.Fatalf("Invalid tus temp directory: %v", err)
 	}
+	if err := os.MkdirAll(tusTmpDir, 0755); err != nil {
+		log.Fatalf("Failed to create tus temp directory: %v", err)
+	}
+
+	janitorStop := make(chan struct{})
+	go runShortLinkJanitor(janitorStop)
+	go runTusJanitor(janitorStop)
+	defer close(janitorStop)
 
 	mux := http.NewServeMux()
-	mux.Handle("/", securityMiddleware(http.HandlerFunc(listHandler)))
+	mux.Handle("/", securityMiddleware(http.HandlerFunc(rootHandler)))
 	mux.Handle("/upload", securityMiddleware(http.HandlerFunc(uploadHandler)))
 	mux.Handle("/download/", securityMiddleware(http.HandlerFunc(downloadHandler)))
 	mux.Handle("/delete/", securityMiddleware(http.HandlerFunc(deleteHandler)))
+	mux.Handle("/s/upload", securityMiddleware(http.HandlerFunc(shortUploadHandler)))
+	mux.Handle("/s/delete/", securityMiddleware(http.HandlerFunc(shortDeleteHandler)))
+	mux.Handle("/api/v1/files", securityMiddleware(http.HandlerFunc(apiFilesHandler)))
+	mux.Handle("/api/v1/files/", securityMiddleware(http.HandlerFunc(apiFileHandler)))
+	mux.Handle("/files/", securityMiddleware(http.HandlerFunc(tusHandler)))
+	mux.Handle("/admin/users", securityMiddleware(http.HandlerFunc(adminUsersHandler)))
+	mux.Handle("/archive.zip", securityMiddleware(http.HandlerFunc(archiveZipHandler)))
+	mux.Handle("/archive.tar.gz", securityMiddleware(http.HandlerFunc(archiveTarGzHandler)))
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
+		Addr:         fmt.Sprintf(":%d", *port),
 		Handler:      mux,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -314,7 +470,7 @@ func main() {
 	}
 
 	go func() {
-		log.Printf("Server started on http://127.0.0.1:%d serving %s", port, baseDir)
+		log.Printf("Server started on http://127.0.0.1:%d using %s backend", *port, *backendKind)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	shortLinkDB     *bbolt.DB
+	shortLinkBucket = []byte("shortlinks")
+)
+
+// shortLinkEntry is the bbolt-persisted record for a random-key upload.
+type shortLinkEntry struct {
+	Key         string    `json:"key"`
+	OwnerToken  string    `json:"owner_token"`
+	DeleteToken string    `json:"delete_token"`
+	Expires     time.Time `json:"expires,omitempty"`
+}
+
+func (e shortLinkEntry) expired() bool {
+	return !e.Expires.IsZero() && time.Now().After(e.Expires)
+}
+
+func openShortLinkDB(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(shortLinkBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func putShortLinkEntry(e shortLinkEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return shortLinkDB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(shortLinkBucket).Put([]byte(e.Key), data)
+	})
+}
+
+func getShortLinkEntry(key string) (shortLinkEntry, bool, error) {
+	var e shortLinkEntry
+	found := false
+	err := shortLinkDB.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(shortLinkBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &e)
+	})
+	return e, found, err
+}
+
+func deleteShortLinkEntry(key string) error {
+	return shortLinkDB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(shortLinkBucket).Delete([]byte(key))
+	})
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// generateShortKey picks a random, collision-free key, namespaced under
+// "<prefix>/" when prefix is non-empty (an authenticated upload).
+func generateShortKey(prefix string) (string, error) {
+	for i := 0; i < 10; i++ {
+		token, err := randomToken(6)
+		if err != nil {
+			return "", err
+		}
+		key := token
+		if prefix != "" {
+			key = prefix + "/" + token
+		}
+		exists, err := backend.Exists(key)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return key, nil
+		}
+	}
+	return "", errors.New("failed to generate unique key")
+}
+
+const ownerCookieName = "gofile_owner"
+
+func ownerToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(ownerCookieName); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	token, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ownerCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+	})
+	return token, nil
+}
+
+func parseExpires(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Now().Add(time.Duration(secs) * time.Second), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+func shortUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 500<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, "File too large or malformed request", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	expires, err := parseExpires(r.FormValue("expires"))
+	if err != nil {
+		http.Error(w, "Invalid expires value", http.StatusBadRequest)
+		return
+	}
+
+	var prefix string
+	if principal := principalFromContext(r.Context()); principal != nil {
+		if err := reserveQuota(principal, header.Size); err != nil {
+			if errors.Is(err, ErrQuotaExceeded) {
+				http.Error(w, "Quota exceeded", http.StatusRequestEntityTooLarge)
+				return
+			}
+			log.Printf("Quota check error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer releaseQuota(principal.Name, header.Size)
+		prefix = principal.Name
+	}
+
+	key, err := generateShortKey(prefix)
+	if err != nil {
+		log.Printf("Generate key error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := putFile(key, file); err != nil {
+		log.Printf("Save file error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	owner, err := ownerToken(w, r)
+	if err != nil {
+		log.Printf("Owner token error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	deleteToken, err := randomToken(16)
+	if err != nil {
+		log.Printf("Delete token error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := putShortLinkEntry(shortLinkEntry{
+		Key:         key,
+		OwnerToken:  owner,
+		DeleteToken: deleteToken,
+		Expires:     expires,
+	}); err != nil {
+		log.Printf("Index write error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url":          fmt.Sprintf("/download/%s", key),
+		"delete_token": deleteToken,
+	})
+}
+
+func shortDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Path[len("/s/delete/"):]
+	if !validateKey(key) {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+
+	entry, found, err := getShortLinkEntry(key)
+	if err != nil {
+		log.Printf("Index read error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	token := r.FormValue("delete_token")
+	cookie, _ := r.Cookie(ownerCookieName)
+	authorized := (token != "" && token == entry.DeleteToken) ||
+		(cookie != nil && cookie.Value != "" && cookie.Value == entry.OwnerToken)
+	if !authorized {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := deleteFile(key); err != nil && !errors.Is(err, ErrNotExist) {
+		log.Printf("Delete error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := deleteShortLinkEntry(key); err != nil {
+		log.Printf("Index delete error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runShortLinkJanitor walks the index once a minute and removes entries past
+// their expiry, along with their backing object.
+func runShortLinkJanitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			expireShortLinks()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func expireShortLinks() {
+	var expired []string
+	err := shortLinkDB.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(shortLinkBucket).ForEach(func(k, v []byte) error {
+			var e shortLinkEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return nil
+			}
+			if e.expired() {
+				expired = append(expired, e.Key)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Janitor scan error: %v", err)
+		return
+	}
+
+	for _, key := range expired {
+		if err := deleteFile(key); err != nil && !errors.Is(err, ErrNotExist) {
+			log.Printf("Janitor delete error for %s: %v", key, err)
+			continue
+		}
+		if err := deleteShortLinkEntry(key); err != nil {
+			log.Printf("Janitor index delete error for %s: %v", key, err)
+			continue
+		}
+		log.Printf("Janitor expired: %s", key)
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/studio-b12/gowebdav"
+)
+
+// countingReader wraps an io.Reader to track how many bytes have been read
+// through it, for backends whose streaming write APIs don't report a size.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var ErrNotExist = errors.New("object does not exist")
+
+// StorageBackend abstracts where uploaded file content actually lives, so the
+// HTTP handlers never touch baseDir or os.* directly.
+type StorageBackend interface {
+	Put(key string, r io.Reader) (int64, error)
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	Exists(key string) (bool, error)
+	Open(key string) (io.ReadSeekCloser, error)
+	ServeFile(w http.ResponseWriter, r *http.Request, key string) error
+	Size(key string) (int64, error)
+	List() ([]FileInfo, error)
+	// ListPrefix lists the files stored under a "/"-prefixed namespace, e.g.
+	// a per-user upload directory. Names are returned relative to prefix.
+	ListPrefix(prefix string) ([]FileInfo, error)
+}
+
+// LocalBackend stores files directly under baseDir on the local filesystem.
+type LocalBackend struct {
+	dir string
+
+	dedupMu  sync.Mutex
+	dedupIdx *dedupIndex
+}
+
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{dir: dir}
+}
+
+// path validates key as one or more "/"-separated segments (e.g. a plain
+// filename, or "<user>/<filename>" for per-user namespacing) and resolves it
+// to a path guaranteed to stay under b.dir.
+func (b *LocalBackend) path(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsRune(segment, '\\') {
+			return "", false
+		}
+	}
+	fullPath := filepath.Join(b.dir, filepath.FromSlash(key))
+	cleanPath := filepath.Clean(fullPath)
+	if !strings.HasPrefix(cleanPath, b.dir) {
+		return "", false
+	}
+	return cleanPath, true
+}
+
+func (b *LocalBackend) Put(key string, r io.Reader) (int64, error) {
+	path, ok := b.path(key)
+	if !ok {
+		return 0, fmt.Errorf("invalid key %q", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	dst, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	return io.Copy(dst, r)
+}
+
+func (b *LocalBackend) Get(key string) (io.ReadCloser, error) {
+	path, ok := b.path(key)
+	if !ok {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (b *LocalBackend) Open(key string) (io.ReadSeekCloser, error) {
+	path, ok := b.path(key)
+	if !ok {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (b *LocalBackend) Delete(key string) error {
+	path, ok := b.path(key)
+	if !ok {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *LocalBackend) Exists(key string) (bool, error) {
+	path, ok := b.path(key)
+	if !ok {
+		return false, fmt.Errorf("invalid key %q", key)
+	}
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *LocalBackend) Size(key string) (int64, error) {
+	path, ok := b.path(key)
+	if !ok {
+		return 0, fmt.Errorf("invalid key %q", key)
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, ErrNotExist
+	} else if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (b *LocalBackend) ServeFile(w http.ResponseWriter, r *http.Request, key string) error {
+	path, ok := b.path(key)
+	if !ok {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrNotExist
+	}
+	http.ServeFile(w, r, path)
+	return nil
+}
+
+func (b *LocalBackend) List() ([]FileInfo, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileInfo{Name: entry.Name(), Size: formatSize(info.Size()), Bytes: info.Size()})
+	}
+	return files, nil
+}
+
+func (b *LocalBackend) ListPrefix(prefix string) ([]FileInfo, error) {
+	dir := filepath.Join(b.dir, filepath.FromSlash(prefix))
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileInfo{Name: entry.Name(), Size: formatSize(info.Size()), Bytes: info.Size()})
+	}
+	return files, nil
+}
+
+// S3Backend stores files as objects in an S3-compatible bucket, so the
+// server's own disk never holds uploaded content.
+type S3Backend struct {
+	client *s3.Client
+	bucket string
+}
+
+func NewS3Backend(ctx context.Context, bucket, region, endpoint string) (*S3Backend, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %w", err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Backend{client: client, bucket: bucket}, nil
+}
+
+func (b *S3Backend) Put(key string, r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	_, err := manager.NewUploader(b.client).Upload(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Body:   cr,
+	})
+	return cr.n, err
+}
+
+func (b *S3Backend) Get(key string) (io.ReadCloser, error) {
+	out, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, translateS3Error(err)
+	}
+	return out.Body, nil
+}
+
+func (b *S3Backend) Open(key string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("s3 backend does not support seekable opens")
+}
+
+func (b *S3Backend) Delete(key string) error {
+	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (b *S3Backend) Exists(key string) (bool, error) {
+	_, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if errors.Is(translateS3Error(err), ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *S3Backend) Size(key string) (int64, error) {
+	out, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, translateS3Error(err)
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+func (b *S3Backend) ServeFile(w http.ResponseWriter, r *http.Request, key string) error {
+	body, err := b.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func (b *S3Backend) List() ([]FileInfo, error) {
+	out, err := b.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(out.Contents))
+	for _, obj := range out.Contents {
+		files = append(files, FileInfo{Name: aws.ToString(obj.Key), Size: formatSize(aws.ToInt64(obj.Size)), Bytes: aws.ToInt64(obj.Size)})
+	}
+	return files, nil
+}
+
+func (b *S3Backend) ListPrefix(prefix string) ([]FileInfo, error) {
+	out, err := b.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix + "/"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(out.Contents))
+	for _, obj := range out.Contents {
+		name := strings.TrimPrefix(aws.ToString(obj.Key), prefix+"/")
+		files = append(files, FileInfo{Name: name, Size: formatSize(aws.ToInt64(obj.Size)), Bytes: aws.ToInt64(obj.Size)})
+	}
+	return files, nil
+}
+
+func translateS3Error(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return ErrNotExist
+		}
+	}
+	return err
+}
+
+// WebDAVBackend stores files on a remote WebDAV share.
+type WebDAVBackend struct {
+	client *gowebdav.Client
+}
+
+func NewWebDAVBackend(url, user, pass string) *WebDAVBackend {
+	return &WebDAVBackend{client: gowebdav.NewClient(url, user, pass)}
+}
+
+func (b *WebDAVBackend) Put(key string, r io.Reader) (int64, error) {
+	if dir := filepath.Dir(key); dir != "." {
+		if err := b.client.MkdirAll(dir, 0755); err != nil {
+			return 0, err
+		}
+	}
+	cr := &countingReader{r: r}
+	if err := b.client.WriteStream(key, cr, 0644); err != nil {
+		return 0, err
+	}
+	return cr.n, nil
+}
+
+func (b *WebDAVBackend) Get(key string) (io.ReadCloser, error) {
+	r, err := b.client.ReadStream(key)
+	if err != nil {
+		return nil, translateWebDAVError(err)
+	}
+	return r, nil
+}
+
+func (b *WebDAVBackend) Open(key string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("webdav backend does not support seekable opens")
+}
+
+func (b *WebDAVBackend) Delete(key string) error {
+	if err := b.client.Remove(key); err != nil {
+		return translateWebDAVError(err)
+	}
+	return nil
+}
+
+func (b *WebDAVBackend) Exists(key string) (bool, error) {
+	_, err := b.client.Stat(key)
+	if err != nil {
+		if errors.Is(translateWebDAVError(err), ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *WebDAVBackend) Size(key string) (int64, error) {
+	info, err := b.client.Stat(key)
+	if err != nil {
+		return 0, translateWebDAVError(err)
+	}
+	return info.Size(), nil
+}
+
+func (b *WebDAVBackend) ServeFile(w http.ResponseWriter, r *http.Request, key string) error {
+	body, err := b.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func (b *WebDAVBackend) List() ([]FileInfo, error) {
+	entries, err := b.client.ReadDir("/")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		files = append(files, FileInfo{Name: entry.Name(), Size: formatSize(entry.Size()), Bytes: entry.Size()})
+	}
+	return files, nil
+}
+
+func (b *WebDAVBackend) ListPrefix(prefix string) ([]FileInfo, error) {
+	entries, err := b.client.ReadDir(prefix)
+	if err != nil {
+		if errors.Is(translateWebDAVError(err), ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		files = append(files, FileInfo{Name: entry.Name(), Size: formatSize(entry.Size()), Bytes: entry.Size()})
+	}
+	return files, nil
+}
+
+func translateWebDAVError(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tusResumableVersion = "1.0.0"
+	tusExtensions       = "creation,expiration,termination"
+	tusUploadTTL        = 24 * time.Hour
+)
+
+var tusTmpDir string
+
+// tusInfo is the sidecar `.info` file tracked alongside each in-progress
+// tus upload's temp data file.
+type tusInfo struct {
+	ID       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  time.Time         `json:"expires"`
+	Owner    string            `json:"owner,omitempty"`
+}
+
+func tusDataPath(id string) string { return filepath.Join(tusTmpDir, id) }
+func tusInfoPath(id string) string { return filepath.Join(tusTmpDir, id+".info") }
+
+func writeTusInfo(info tusInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := tusInfoPath(info.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tusInfoPath(info.ID))
+}
+
+func readTusInfo(id string) (tusInfo, error) {
+	var info tusInfo
+	data, err := os.ReadFile(tusInfoPath(id))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata
+	}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		parts := strings.SplitN(pair, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		metadata[parts[0]] = string(value)
+	}
+	return metadata
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+}
+
+// tusHandler implements the tus.io v1.0.0 resumable upload protocol on
+// /files/ and /files/{id}.
+func tusHandler(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	switch r.Method {
+	case http.MethodPost:
+		tusCreateHandler(w, r)
+	case http.MethodHead:
+		tusHeadHandler(w, r)
+	case http.MethodPatch:
+		tusPatchHandler(w, r)
+	case http.MethodDelete:
+		tusDeleteHandler(w, r)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func tusCreateHandler(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, "Invalid or missing Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if name := metadata["filename"]; name != "" && !validateFilename(filepath.Base(name)) {
+		http.Error(w, "Invalid filename in Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	var owner string
+	if principal := principalFromContext(r.Context()); principal != nil {
+		if err := reserveQuota(principal, size); err != nil {
+			if errors.Is(err, ErrQuotaExceeded) {
+				http.Error(w, "Quota exceeded", http.StatusRequestEntityTooLarge)
+				return
+			}
+			log.Printf("Quota check error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// The reservation is held across this upload's whole create/patch
+		// lifecycle, not just this request; it's released in tusFinish,
+		// tusDeleteHandler, or expireTusUploads - whichever ends it.
+		owner = principal.Name
+	}
+
+	id, err := randomToken(16)
+	if err != nil {
+		log.Printf("Generate upload id error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Create(tusDataPath(id))
+	if err != nil {
+		log.Printf("Create tus upload error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	info := tusInfo{ID: id, Size: size, Offset: 0, Metadata: metadata, Expires: time.Now().Add(tusUploadTTL), Owner: owner}
+	if err := writeTusInfo(info); err != nil {
+		log.Printf("Write tus info error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/files/%s", id))
+	w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func tusUploadID(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, "/files/")
+}
+
+func tusHeadHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := readTusInfo(tusUploadID(r))
+	if err != nil {
+		http.Error(w, "Upload Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func tusPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := tusUploadID(r)
+	info, err := readTusInfo(id)
+	if err != nil {
+		http.Error(w, "Upload Not Found", http.StatusNotFound)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != info.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(tusDataPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Open tus upload error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("Seek tus upload error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, info.Size-offset)
+	written, err := io.Copy(f, r.Body)
+	if err != nil {
+		log.Printf("Write tus upload error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	info.Offset += written
+	info.Expires = time.Now().Add(tusUploadTTL)
+	if err := writeTusInfo(info); err != nil {
+		log.Printf("Write tus info error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+
+	if info.Offset >= info.Size {
+		if err := tusFinish(info); err != nil {
+			if errors.Is(err, ErrNameConflict) {
+				http.Error(w, "File already exists", http.StatusConflict)
+				return
+			}
+			log.Printf("Finish tus upload error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tusFinish(info tusInfo) error {
+	if info.Owner != "" {
+		defer releaseQuota(info.Owner, info.Size)
+	}
+
+	name := filepath.Base(info.Metadata["filename"])
+	if !validateFilename(name) {
+		name = info.ID
+	}
+	key := name
+	if info.Owner != "" {
+		key = info.Owner + "/" + name
+	}
+
+	f, err := os.Open(tusDataPath(info.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := putFile(key, f); err != nil {
+		return err
+	}
+
+	os.Remove(tusDataPath(info.ID))
+	os.Remove(tusInfoPath(info.ID))
+	log.Printf("Tus upload complete: %s -> %s", info.ID, key)
+	return nil
+}
+
+func tusDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := tusUploadID(r)
+	info, err := readTusInfo(id)
+	if err != nil {
+		http.Error(w, "Upload Not Found", http.StatusNotFound)
+		return
+	}
+	if info.Owner != "" {
+		releaseQuota(info.Owner, info.Size)
+	}
+	os.Remove(tusDataPath(id))
+	os.Remove(tusInfoPath(id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runTusJanitor periodically removes incomplete tus uploads past their
+// Upload-Expires deadline.
+func runTusJanitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			expireTusUploads()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func expireTusUploads() {
+	entries, err := os.ReadDir(tusTmpDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".info") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".info")
+		info, err := readTusInfo(id)
+		if err != nil {
+			continue
+		}
+		if time.Now().After(info.Expires) {
+			if info.Owner != "" {
+				releaseQuota(info.Owner, info.Size)
+			}
+			os.Remove(tusDataPath(id))
+			os.Remove(tusInfoPath(id))
+			log.Printf("Janitor expired incomplete tus upload: %s", id)
+		}
+	}
+}